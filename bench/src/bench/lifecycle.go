@@ -0,0 +1,121 @@
+package bench
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventState is a snapshot of an event's Public/Closed flags, used to
+// describe the from/to sides of a LifecycleEntry.
+type EventState struct {
+	Public bool
+	Closed bool
+}
+
+// LifecycleEntry records a single TransitionEvent call so the checker can
+// validate that GET /api/events and GET /admin/api/events reflect the last
+// observed admin action instead of lagging behind it.
+type LifecycleEntry struct {
+	Timestamp time.Time
+	From      EventState
+	To        EventState
+	AdminID   uint // 0 if the transition wasn't attributed to an admin
+}
+
+// TransitionEvent atomically updates eventID's PublicFg/ClosedFg and
+// migrates its unreserved *EventSheet entries between eventSheets,
+// privateEventSheets and closedEventSheets to match. A closed event can
+// never become public or private again; attempting to do so (or any other
+// transition once closed) returns an error instead of mutating state.
+// adminID is recorded on the resulting LifecycleEntry; pass 0 when the
+// transition isn't attributed to an admin.
+func (s *State) TransitionEvent(eventID uint, toPublic, toClosed bool, adminID uint) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var event *Event
+	for _, e := range s.events {
+		if e.ID == eventID {
+			event = e
+			break
+		}
+	}
+	if event == nil {
+		return fmt.Errorf("bench: TransitionEvent: unknown event %d", eventID)
+	}
+	if toPublic && toClosed {
+		return fmt.Errorf("bench: TransitionEvent: event %d cannot be public and closed at once", eventID)
+	}
+
+	from := EventState{Public: event.PublicFg, Closed: event.ClosedFg}
+	to := EventState{Public: toPublic, Closed: toClosed}
+
+	if from == to {
+		return nil
+	}
+	if from.Closed {
+		return fmt.Errorf("bench: TransitionEvent: event %d is closed and cannot transition to %+v", eventID, to)
+	}
+
+	s.migrateEventSheetsLocked(eventID, from, to)
+	event.PublicFg = toPublic
+	event.ClosedFg = toClosed
+
+	s.eventLifecycleMtx.Lock()
+	s.eventLifecycle[eventID] = append(s.eventLifecycle[eventID], LifecycleEntry{
+		Timestamp: time.Now(),
+		From:      from,
+		To:        to,
+		AdminID:   adminID,
+	})
+	s.eventLifecycleMtx.Unlock()
+
+	return nil
+}
+
+// eventSheetPoolLocked returns the *EventSheet pool that an event in state
+// belongs to. Must be called with s.mtx held.
+func (s *State) eventSheetPoolLocked(state EventState) *[]*EventSheet {
+	switch {
+	case state.Closed:
+		return &s.closedEventSheets
+	case !state.Public:
+		return &s.privateEventSheets
+	default:
+		return &s.eventSheets
+	}
+}
+
+// migrateEventSheetsLocked moves eventID's unreserved sheets from the pool
+// matching from to the pool matching to. Reserved sheets are left where
+// they are; they live in reservedEventSheets until canceled, independent of
+// the event's Public/Closed flags. Must be called with s.mtx held.
+func (s *State) migrateEventSheetsLocked(eventID uint, from, to EventState) {
+	fromPool := s.eventSheetPoolLocked(from)
+	toPool := s.eventSheetPoolLocked(to)
+	if fromPool == toPool {
+		return
+	}
+
+	remaining := (*fromPool)[:0]
+	for _, es := range *fromPool {
+		if es.EventID == eventID && es.Num == NonReservedNum {
+			*toPool = append(*toPool, es)
+		} else {
+			remaining = append(remaining, es)
+		}
+	}
+	*fromPool = remaining
+}
+
+// EventLifecycle returns eventID's transition history in the order
+// TransitionEvent applied it.
+func (s *State) EventLifecycle(eventID uint) []LifecycleEntry {
+	s.eventLifecycleMtx.Lock()
+	defer s.eventLifecycleMtx.Unlock()
+
+	history := s.eventLifecycle[eventID]
+	out := make([]LifecycleEntry, len(history))
+	copy(out, history)
+	return out
+}
@@ -0,0 +1,212 @@
+package bench
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// reservationShardCount is the number of shards State.reservationShards,
+// reserveLogShards and cancelLogShards are split into. Splitting by
+// id % reservationShardCount means a reserve/cancel goroutine only
+// contends with others that happen to land on the same shard, instead of
+// every goroutine serializing on one lock.
+const reservationShardCount = 16
+
+type reservationShard struct {
+	mtx sync.RWMutex
+	m   map[uint]*Reservation // key: reservation id
+}
+
+type logShard struct {
+	mtx sync.RWMutex
+	m   map[uint64]*Reservation // key: reserveLogID or cancelLogID
+}
+
+func (s *State) reservationShardFor(id uint) *reservationShard {
+	return s.reservationShards[id%reservationShardCount]
+}
+
+func (s *State) reserveLogShardFor(logID uint64) *logShard {
+	return s.reserveLogShards[logID%reservationShardCount]
+}
+
+func (s *State) cancelLogShardFor(logID uint64) *logShard {
+	return s.cancelLogShards[logID%reservationShardCount]
+}
+
+// AppendReservation and DeleteReservation both need to mutate the
+// reservation's shard entry (or its Deleted flag) and the heldSheets/
+// heldCounts bookkeeping as a single atomic step: if the shard write and the
+// heldSheets write were protected by two independently-acquired locks, a
+// DeleteReservation could run entirely between them and see no heldSheets
+// entry to clean up, leaking a heldCounts increment forever. So
+// heldSheetsMtx is held across both the shard mutation and the heldSheets/
+// heldCounts mutation here.
+func (s *State) AppendReservation(reservation *Reservation) {
+	key := eventSheetKey{reservation.EventID, reservation.SheetRank, reservation.SheetNum}
+	rankKey := eventRankKey{reservation.EventID, reservation.SheetRank}
+
+	s.heldSheetsMtx.Lock()
+
+	shard := s.reservationShardFor(reservation.ID)
+	shard.mtx.Lock()
+	shard.m[reservation.ID] = reservation
+	shard.mtx.Unlock()
+
+	held, doubleBooked := s.heldSheets[key]
+	doubleBooked = doubleBooked && !held.Deleted
+	if !doubleBooked {
+		s.heldCounts[rankKey]++
+	}
+	s.heldSheets[key] = reservation
+
+	s.heldSheetsMtx.Unlock()
+
+	if doubleBooked {
+		log.Printf("critical: double booking detected EventID:%d SheetRank:%s SheetNum:%d ReservationID:%d conflicts with ReservationID:%d\n", reservation.EventID, reservation.SheetRank, reservation.SheetNum, reservation.ID, held.ID)
+	}
+}
+
+func (s *State) DeleteReservation(reservationID uint) {
+	shard := s.reservationShardFor(reservationID)
+
+	s.heldSheetsMtx.Lock()
+
+	shard.mtx.Lock()
+	reservation := shard.m[reservationID]
+	reservation.Deleted = true
+	shard.mtx.Unlock()
+
+	key := eventSheetKey{reservation.EventID, reservation.SheetRank, reservation.SheetNum}
+	rankKey := eventRankKey{reservation.EventID, reservation.SheetRank}
+	if held, ok := s.heldSheets[key]; ok && held.ID == reservation.ID {
+		delete(s.heldSheets, key)
+		s.heldCounts[rankKey]--
+	}
+
+	s.heldSheetsMtx.Unlock()
+}
+
+// FindActiveReservation returns the reservation currently holding
+// (eventID, rank, num), or nil if the seat is free.
+func (s *State) FindActiveReservation(eventID uint, rank string, num uint) *Reservation {
+	s.heldSheetsMtx.RLock()
+	defer s.heldSheetsMtx.RUnlock()
+
+	held, ok := s.heldSheets[eventSheetKey{eventID, rank, num}]
+	if !ok || held.Deleted {
+		return nil
+	}
+	return held
+}
+
+// ActiveReservationCount returns how many sheets of rank are currently held
+// for eventID, so JsonEvent.Sheets[rank].Remains can be cross-checked
+// against local ground truth in O(1) instead of scanning all reservations.
+func (s *State) ActiveReservationCount(eventID uint, rank string) uint {
+	s.heldSheetsMtx.RLock()
+	defer s.heldSheetsMtx.RUnlock()
+
+	return s.heldCounts[eventRankKey{eventID, rank}]
+}
+
+// MarkReserved records the server-reported reserved_at for a reservation
+// once the reserve request has been confirmed to have succeeded.
+func (s *State) MarkReserved(reservationID uint, reservedAt time.Time) {
+	shard := s.reservationShardFor(reservationID)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+
+	shard.m[reservationID].ReservedAt = reservedAt
+}
+
+// MarkCanceled records the server-reported canceled_at for a reservation
+// once the cancel request has been confirmed to have succeeded.
+func (s *State) MarkCanceled(reservationID uint, canceledAt time.Time) {
+	shard := s.reservationShardFor(reservationID)
+	shard.mtx.Lock()
+	defer shard.mtx.Unlock()
+
+	shard.m[reservationID].CanceledAt = canceledAt
+}
+
+// WalkReservations calls fn for every reservation across all shards,
+// locking (and unlocking) one shard at a time rather than all shards at
+// once. Iteration stops early if fn returns false.
+func (s *State) WalkReservations(fn func(*Reservation) bool) {
+	for _, shard := range s.reservationShards {
+		shard.mtx.RLock()
+		for _, reservation := range shard.m {
+			if !fn(reservation) {
+				shard.mtx.RUnlock()
+				return
+			}
+		}
+		shard.mtx.RUnlock()
+	}
+}
+
+// ReservationsByEvent returns every reservation for eventID ordered by
+// ReservedAt, oldest first, so the checker can verify a server never
+// reorders or drops reservations under concurrent load.
+func (s *State) ReservationsByEvent(eventID uint) []*Reservation {
+	reservations := []*Reservation{}
+	s.WalkReservations(func(reservation *Reservation) bool {
+		if reservation.EventID == eventID {
+			reservations = append(reservations, reservation)
+		}
+		return true
+	})
+
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].ReservedAt.Before(reservations[j].ReservedAt)
+	})
+
+	return reservations
+}
+
+func (s *State) AppendReserveLog(reservation *Reservation) uint64 {
+	logID := s.reserveLogID.Add(1)
+	reservation.ReserveSeq = logID
+
+	shard := s.reserveLogShardFor(logID)
+	shard.mtx.Lock()
+	shard.m[logID] = reservation
+	shard.mtx.Unlock()
+
+	log.Printf("debug: appendReserveLog LogID:%2d EventID:%2d UserID:%3d SheetRank:%s\n", logID, reservation.EventID, reservation.UserID, reservation.SheetRank)
+	return logID
+}
+
+func (s *State) DeleteReserveLog(reserveLogID uint64, reservation *Reservation) {
+	shard := s.reserveLogShardFor(reserveLogID)
+	shard.mtx.Lock()
+	delete(shard.m, reserveLogID)
+	shard.mtx.Unlock()
+
+	log.Printf("debug: deleteReserveLog LogID:%2d EventID:%2d UserID:%3d SheetRank:%s SheetNum:%d ReservationID:%d (Reserved)\n", reserveLogID, reservation.EventID, reservation.UserID, reservation.SheetRank, reservation.SheetNum, reservation.ID)
+}
+
+func (s *State) AppendCancelLog(reservation *Reservation) uint64 {
+	logID := s.cancelLogID.Add(1)
+	reservation.CancelSeq = logID
+
+	shard := s.cancelLogShardFor(logID)
+	shard.mtx.Lock()
+	shard.m[logID] = reservation
+	shard.mtx.Unlock()
+
+	log.Printf("debug: appendCancelLog  LogID:%2d EventID:%2d UserID:%3d SheetRank:%s SheetNum:%d ReservationID:%d\n", logID, reservation.EventID, reservation.UserID, reservation.SheetRank, reservation.SheetNum, reservation.ID)
+	return logID
+}
+
+func (s *State) DeleteCancelLog(cancelLogID uint64, reservation *Reservation) {
+	shard := s.cancelLogShardFor(cancelLogID)
+	shard.mtx.Lock()
+	delete(shard.m, cancelLogID)
+	shard.mtx.Unlock()
+
+	log.Printf("debug: deleteCancelLog  LogID:%2d EventID:%2d UserID:%3d SheetRank:%s SheetNum:%d ReservationID:%d (Canceled)\n", cancelLogID, reservation.EventID, reservation.UserID, reservation.SheetRank, reservation.SheetNum, reservation.ID)
+}
@@ -0,0 +1,195 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/LK4D4/trylock"
+)
+
+// SnapshotVersion is bumped whenever the Snapshot format gains or changes a
+// field in a way that an older Restore would misinterpret. Restore rejects
+// any snapshot whose Version it does not recognize.
+const SnapshotVersion = 1
+
+// reserveLogEntry/cancelLogEntry pair a log ID with its reservation so the
+// map form of reserveLogShards/cancelLogShards survives a JSON round-trip.
+type reserveLogEntry struct {
+	LogID       uint64       `json:"log_id"`
+	Reservation *Reservation `json:"reservation"`
+}
+
+type cancelLogEntry struct {
+	LogID       uint64       `json:"log_id"`
+	Reservation *Reservation `json:"reservation"`
+}
+
+// stateSnapshot is the self-describing, forward-compatible JSON form of
+// State. It is the only thing Snapshot/Restore agree on; State itself keeps
+// its sync.Mutex/trylock.Mutex fields, which cannot be serialized.
+type stateSnapshot struct {
+	Version int `json:"version"`
+
+	Users    []*AppUser       `json:"users"`
+	NewUsers []*AppUser       `json:"new_users"`
+	Admins   []*Administrator `json:"admins"`
+
+	Events    []*Event                  `json:"events"`
+	Lifecycle map[uint][]LifecycleEntry `json:"lifecycle"`
+
+	EventSheets         []*EventSheet `json:"event_sheets"`
+	PrivateEventSheets  []*EventSheet `json:"private_event_sheets"`
+	ClosedEventSheets   []*EventSheet `json:"closed_event_sheets"`
+	ReservedEventSheets []*EventSheet `json:"reserved_event_sheets"`
+
+	Reservations []*Reservation `json:"reservations"`
+
+	ReserveLogID uint64            `json:"reserve_log_id"`
+	ReserveLog   []reserveLogEntry `json:"reserve_log"`
+	CancelLogID  uint64            `json:"cancel_log_id"`
+	CancelLog    []cancelLogEntry  `json:"cancel_log"`
+}
+
+// Snapshot serializes the entire benchmark ground truth (users, admins,
+// events and their lifecycle history, event-sheet pools, reservations, and
+// the outstanding reserve/cancel logs) to a self-describing JSON blob that
+// Restore accepts. The driver does not call it: no snapshot is written on a
+// FAIL result.
+func (s *State) Snapshot() ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	snap := stateSnapshot{
+		Version: SnapshotVersion,
+
+		Users:    s.users,
+		NewUsers: s.newUsers,
+		Admins:   s.admins,
+
+		Events: s.events,
+
+		EventSheets:         s.eventSheets,
+		PrivateEventSheets:  s.privateEventSheets,
+		ClosedEventSheets:   s.closedEventSheets,
+		ReservedEventSheets: s.reservedEventSheets,
+
+		ReserveLogID: s.reserveLogID.Load(),
+		CancelLogID:  s.cancelLogID.Load(),
+	}
+
+	s.eventLifecycleMtx.Lock()
+	snap.Lifecycle = make(map[uint][]LifecycleEntry, len(s.eventLifecycle))
+	for eventID, history := range s.eventLifecycle {
+		entries := make([]LifecycleEntry, len(history))
+		copy(entries, history)
+		snap.Lifecycle[eventID] = entries
+	}
+	s.eventLifecycleMtx.Unlock()
+
+	snap.Reservations = []*Reservation{}
+	s.WalkReservations(func(reservation *Reservation) bool {
+		snap.Reservations = append(snap.Reservations, reservation)
+		return true
+	})
+
+	snap.ReserveLog = []reserveLogEntry{}
+	for _, shard := range s.reserveLogShards {
+		shard.mtx.RLock()
+		for logID, reservation := range shard.m {
+			snap.ReserveLog = append(snap.ReserveLog, reserveLogEntry{logID, reservation})
+		}
+		shard.mtx.RUnlock()
+	}
+
+	snap.CancelLog = []cancelLogEntry{}
+	for _, shard := range s.cancelLogShards {
+		shard.mtx.RLock()
+		for logID, reservation := range shard.m {
+			snap.CancelLog = append(snap.CancelLog, cancelLogEntry{logID, reservation})
+		}
+		shard.mtx.RUnlock()
+	}
+
+	return json.Marshal(snap)
+}
+
+// Restore replaces the receiver's contents with the ground truth encoded in
+// data, as produced by Snapshot. The sync.Mutex/trylock.Mutex fields are
+// reset fresh, and userMap/adminMap are rebuilt from the flat slices;
+// checkerMap/adminCheckerMap start empty and are repopulated lazily
+// via GetChecker/GetAdminChecker, same as Init does.
+func (s *State) Restore(data []byte) error {
+	var snap stateSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("bench: unsupported snapshot version %d (want %d)", snap.Version, SnapshotVersion)
+	}
+
+	s.mtx = sync.Mutex{}
+	s.newEventMtx = trylock.Mutex{}
+	s.heldSheetsMtx = sync.RWMutex{}
+	s.eventLifecycleMtx = sync.Mutex{}
+
+	s.users = snap.Users
+	s.newUsers = snap.NewUsers
+	s.userMap = map[string]*AppUser{}
+	for _, u := range s.users {
+		s.userMap[u.LoginName] = u
+	}
+	s.checkerMap = map[*AppUser]*Checker{}
+
+	s.admins = snap.Admins
+	s.adminMap = map[string]*Administrator{}
+	for _, a := range s.admins {
+		s.adminMap[a.LoginName] = a
+	}
+	s.adminCheckerMap = map[*Administrator]*Checker{}
+
+	s.events = snap.Events
+
+	s.eventLifecycle = map[uint][]LifecycleEntry{}
+	for eventID, history := range snap.Lifecycle {
+		entries := make([]LifecycleEntry, len(history))
+		copy(entries, history)
+		s.eventLifecycle[eventID] = entries
+	}
+
+	s.eventSheets = snap.EventSheets
+	s.privateEventSheets = snap.PrivateEventSheets
+	s.closedEventSheets = snap.ClosedEventSheets
+	s.reservedEventSheets = snap.ReservedEventSheets
+
+	for i := range s.reservationShards {
+		s.reservationShards[i] = &reservationShard{m: map[uint]*Reservation{}}
+		s.reserveLogShards[i] = &logShard{m: map[uint64]*Reservation{}}
+		s.cancelLogShards[i] = &logShard{m: map[uint64]*Reservation{}}
+	}
+	s.heldSheets = map[eventSheetKey]*Reservation{}
+	s.heldCounts = map[eventRankKey]uint{}
+	for _, reservation := range snap.Reservations {
+		shard := s.reservationShardFor(reservation.ID)
+		shard.m[reservation.ID] = reservation
+		if !reservation.Deleted {
+			s.heldSheets[eventSheetKey{reservation.EventID, reservation.SheetRank, reservation.SheetNum}] = reservation
+			s.heldCounts[eventRankKey{reservation.EventID, reservation.SheetRank}]++
+		}
+	}
+
+	s.reserveLogID.Store(snap.ReserveLogID)
+	for _, entry := range snap.ReserveLog {
+		shard := s.reserveLogShardFor(entry.LogID)
+		shard.m[entry.LogID] = entry.Reservation
+	}
+
+	s.cancelLogID.Store(snap.CancelLogID)
+	for _, entry := range snap.CancelLog {
+		shard := s.cancelLogShardFor(entry.LogID)
+		shard.m[entry.LogID] = entry.Reservation
+	}
+
+	return nil
+}
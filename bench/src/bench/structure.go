@@ -4,6 +4,7 @@ import (
 	"log"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/LK4D4/trylock"
@@ -101,13 +102,21 @@ type Sheet struct {
 }
 
 type Reservation struct {
-	ID        uint
-	EventID   uint
-	UserID    uint
-	SheetRank string
-	SheetNum  uint
-	Deleted   bool
-	// ReservedAt uint // No way to obtain now
+	ID         uint
+	EventID    uint
+	UserID     uint
+	SheetRank  string
+	SheetNum   uint
+	Deleted    bool
+	ReservedAt time.Time
+	CanceledAt time.Time
+
+	// ReserveSeq/CancelSeq mirror the reserveLogID/cancelLogID assigned when
+	// this reservation entered reserveLogShards/cancelLogShards, so that once the
+	// reserve/cancel request is confirmed we can correlate the log entry
+	// back to the ReservedAt/CanceledAt reported by the server.
+	ReserveSeq uint64
+	CancelSeq  uint64
 }
 
 type BenchDataSet struct {
@@ -132,6 +141,21 @@ type EventSheet struct {
 	Num     uint
 }
 
+// eventSheetKey identifies a single seat within an event, used to look up
+// whether it is currently held by a reservation.
+type eventSheetKey struct {
+	EventID uint
+	Rank    string
+	Num     uint
+}
+
+// eventRankKey identifies a (event, rank) pair, used to key the
+// incrementally-maintained heldCounts index.
+type eventRankKey struct {
+	EventID uint
+	Rank    string
+}
+
 type State struct {
 	mtx         sync.Mutex
 	newEventMtx trylock.Mutex
@@ -147,24 +171,31 @@ type State struct {
 
 	events []*Event
 
+	eventLifecycleMtx sync.Mutex
+	eventLifecycle    map[uint][]LifecycleEntry // key: event id
+
 	// public && closed does not happen
 	eventSheets         []*EventSheet // public && !closed
 	privateEventSheets  []*EventSheet // !public && !closed
 	closedEventSheets   []*EventSheet // !public && closed
 	reservedEventSheets []*EventSheet
 
-	reservationsMtx sync.Mutex
-	reservations    map[uint]*Reservation // key: reservation id
+	// reservations is sharded across reservationShardCount shards (see
+	// reservations.go) so that concurrent reserve/cancel goroutines do not
+	// all serialize on a single lock.
+	reservationShards [reservationShardCount]*reservationShard
+	heldSheetsMtx     sync.RWMutex
+	heldSheets        map[eventSheetKey]*Reservation // key: (eventID, rank, num) of the currently active holder
+	heldCounts        map[eventRankKey]uint          // key: (eventID, rank), kept in sync with heldSheets so ActiveReservationCount is O(1)
 
 	// Like a transactional log for reserve/cancel API.
 	// A log is removed after we verified that the reserve/cancel API request succeeded.
 	// If a request is timeouted or failed by any reasons, the log remains kept.
-	reserveLogMtx sync.Mutex
-	reserveLogID  uint64                  // 2^64 should be enough
-	reserveLog    map[uint64]*Reservation // key: reserveLogID
-	cancelLogMtx  sync.Mutex
-	cancelLogID   uint64                  // 2^64 should be enough
-	cancelLog     map[uint64]*Reservation // key: cancelLogID
+	// Both logs are sharded the same way as reservationShards.
+	reserveLogID     atomic.Uint64 // 2^64 should be enough
+	reserveLogShards [reservationShardCount]*logShard
+	cancelLogID      atomic.Uint64 // 2^64 should be enough
+	cancelLogShards  [reservationShardCount]*logShard
 }
 
 func (s *State) Init() {
@@ -184,16 +215,21 @@ func (s *State) Init() {
 		s.pushNewAdministratorLocked(u)
 	}
 
+	s.eventLifecycle = map[uint][]LifecycleEntry{}
 	for _, event := range DataSet.Events {
 		s.pushNewEventLocked(event, "Init")
 	}
 
-	s.reservations = map[uint]*Reservation{}
+	for i := range s.reservationShards {
+		s.reservationShards[i] = &reservationShard{m: map[uint]*Reservation{}}
+		s.reserveLogShards[i] = &logShard{m: map[uint64]*Reservation{}}
+		s.cancelLogShards[i] = &logShard{m: map[uint64]*Reservation{}}
+	}
+	s.heldSheets = map[eventSheetKey]*Reservation{}
+	s.heldCounts = map[eventRankKey]uint{}
 
-	s.reserveLogID = 0
-	s.reserveLog = map[uint64]*Reservation{}
-	s.cancelLogID = 0
-	s.cancelLog = map[uint64]*Reservation{}
+	s.reserveLogID.Store(0)
+	s.cancelLogID.Store(0)
 }
 
 func (s *State) PopRandomUser() (*AppUser, *Checker, func()) {
@@ -483,55 +519,3 @@ func FilterPublicEvents(src []*Event) (filtered []*Event) {
 	}
 	return
 }
-
-func (s *State) AppendReservation(reservation *Reservation) {
-	s.reservationsMtx.Lock()
-	defer s.reservationsMtx.Unlock()
-
-	s.reservations[reservation.ID] = reservation
-}
-
-func (s *State) DeleteReservation(reservationID uint) {
-	s.reservationsMtx.Lock()
-	defer s.reservationsMtx.Unlock()
-
-	s.reservations[reservationID].Deleted = true
-}
-
-func (s *State) AppendReserveLog(reservation *Reservation) uint64 {
-	s.reserveLogMtx.Lock()
-	defer s.reserveLogMtx.Unlock()
-
-	s.reserveLogID++
-	s.reserveLog[s.reserveLogID] = reservation
-
-	log.Printf("debug: appendReserveLog LogID:%2d EventID:%2d UserID:%3d SheetRank:%s\n", s.reserveLogID, reservation.EventID, reservation.UserID, reservation.SheetRank)
-	return s.reserveLogID
-}
-
-func (s *State) DeleteReserveLog(reserveLogID uint64, reservation *Reservation) {
-	s.reserveLogMtx.Lock()
-	defer s.reserveLogMtx.Unlock()
-
-	log.Printf("debug: deleteReserveLog LogID:%2d EventID:%2d UserID:%3d SheetRank:%s SheetNum:%d ReservationID:%d (Reserved)\n", reserveLogID, reservation.EventID, reservation.UserID, reservation.SheetRank, reservation.SheetNum, reservation.ID)
-	delete(s.reserveLog, reserveLogID)
-}
-
-func (s *State) AppendCancelLog(reservation *Reservation) uint64 {
-	s.cancelLogMtx.Lock()
-	defer s.cancelLogMtx.Unlock()
-
-	s.cancelLogID++
-	s.cancelLog[s.cancelLogID] = reservation
-
-	log.Printf("debug: appendCancelLog  LogID:%2d EventID:%2d UserID:%3d SheetRank:%s SheetNum:%d ReservationID:%d\n", s.cancelLogID, reservation.EventID, reservation.UserID, reservation.SheetRank, reservation.SheetNum, reservation.ID)
-	return s.cancelLogID
-}
-
-func (s *State) DeleteCancelLog(cancelLogID uint64, reservation *Reservation) {
-	s.cancelLogMtx.Lock()
-	defer s.cancelLogMtx.Unlock()
-
-	log.Printf("debug: deleteCancelLog  LogID:%2d EventID:%2d UserID:%3d SheetRank:%s SheetNum:%d ReservationID:%d (Canceled)\n", s.cancelLogID, reservation.EventID, reservation.UserID, reservation.SheetRank, reservation.SheetNum, reservation.ID)
-	delete(s.cancelLog, cancelLogID)
-}